@@ -2,67 +2,270 @@ package main
 
 // 导入标准库：fmt 用于打印；time 用于时间戳；crypto/sha256 用于哈希；
 // encoding/hex 把字节转成十六进制字符串；strings 处理字符串前缀匹配；
-// bytes 用于连接字节片；strconv 把数字转字符串，保证拼接时稳定。
+// bytes 用于连接字节片；strconv 把数字转字符串，保证拼接时稳定；
+// errors 用于定义可比较的错误值；unicode 用于识别控制字符；math 用于概率计算；
+// sort 用于统计时排序；io 与 encoding/json 用于导入导出；crypto/ed25519 用于 PoA 签名；
+// context 用于取消长时间运行的校验；math/rand 用于可复现的模拟抽样。
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
+// ErrAmountTooLarge 表示交易金额超过了链上配置的单笔上限 MaxTxAmount。
+var ErrAmountTooLarge = errors.New("transaction amount exceeds MaxTxAmount")
+
+// ErrDustOutput 表示交易金额低于 DustThreshold，属于不经济的“粉尘”输出。
+var ErrDustOutput = errors.New("transaction amount below DustThreshold")
+
+// ErrUnspendableAddress 表示交易试图从被标记为不可花费的地址（如销毁地址）转出。
+var ErrUnspendableAddress = errors.New("address is unspendable")
+
+// ErrMintOutsideGenesis 表示非创世区块中出现了付款方为空的铸币交易。
+var ErrMintOutsideGenesis = errors.New("mint transaction outside genesis")
+
+// ErrInvalidAddress 表示交易地址过长或包含控制字符（如 \x00）。
+var ErrInvalidAddress = errors.New("invalid transaction address")
+
+// ErrImpossibleDifficulty 表示难度为负或超过了哈希的十六进制位数，不可能挖出。
+var ErrImpossibleDifficulty = errors.New("difficulty exceeds hash width")
+
+// ErrFutureBlock 表示区块时间戳超出了本机时钟加允许偏差的范围。
+var ErrFutureBlock = errors.New("block timestamp too far in the future")
+
+// ErrBlockTooLarge 表示区块序列化后的字节数（见 Block.Size）超过了 MaxBlockBytes。
+var ErrBlockTooLarge = errors.New("block exceeds MaxBlockBytes")
+
+// ErrInvalidBlock 表示区块未通过校验（前哈希、哈希或难度不符）。
+var ErrInvalidBlock = errors.New("invalid block")
+
+// ErrMiningGaveUp 表示在 MaxMineAttempts 次尝试内没有找到满足难度的 nonce。
+var ErrMiningGaveUp = errors.New("mining gave up after MaxMineAttempts")
+
+// ErrNoValidatorKey 表示 PoA 模式下没有配置用于签名区块的验证者私钥。
+var ErrNoValidatorKey = errors.New("no validator key configured for PoA")
+
+// ErrUnauthorizedValidator 表示区块的签名者不在授权列表中，或签名无效。
+var ErrUnauthorizedValidator = errors.New("block not signed by an authorized validator")
+
+// ErrOutOfTurn 表示轮流出块模式下，区块不是由该高度轮到的验证者签名的。
+var ErrOutOfTurn = errors.New("block signed by out-of-turn authority")
+
+// ErrInvalidShare 表示矿池份额不满足份额难度、哈希不符或不是基于当前链尾。
+var ErrInvalidShare = errors.New("invalid share")
+
+// ConsensusMode 表示出块方式：工作量证明、权威证明或权益证明。
+type ConsensusMode int
+
+const (
+	PoW ConsensusMode = iota // 工作量证明：挖 nonce 使哈希满足难度（默认）
+	PoA                      // 权威证明：由授权验证者对区块哈希签名，不挖矿
+	PoS                      // 权益证明：按余额加权抽中的质押者签名出块（见 pos.go）
+)
+
+// ErrImplausibleDifficulty 表示链上某个区块的实际难度超出了可信上限，可能是伪造数据。
+var ErrImplausibleDifficulty = errors.New("implausible difficulty")
+
+// ErrCausalOrder 表示交易花掉了它在当时还没有收到的币。
+var ErrCausalOrder = errors.New("spend before receive")
+
+// hashHexLen 是区块哈希的十六进制字符数（SHA-256 为 64），也是难度的上限。
+const hashHexLen = sha256.Size * 2
+
 // Transaction 表示一笔极简交易（只包含 from、to、amount 三个字段）。
+// From 为空串的交易表示铸币（目前只出现在创世区块的初始分配中）。
 type Transaction struct {
-	From   string // 付款方地址或标识（演示用，未做校验）
+	From   string // 付款方地址或标识（仅校验长度与控制字符）
 	To     string // 收款方地址或标识
 	Amount int    // 转账数量，演示用 int 即可
+	Script []byte // 可选的校验脚本（见 script.go），为空时不执行
+}
+
+// ID 返回交易的标识：对该笔交易的稳定序列化结果做 SHA-256 后取十六进制。
+// 内容完全相同的两笔交易会得到相同的 ID。
+func (tx Transaction) ID() string {
+	sum := sha256.Sum256(serializeTransactions([]Transaction{tx}))
+	return hex.EncodeToString(sum[:])
+}
+
+// Receipt 是交易被打包上链后的回执，记录它落在哪个区块的哪个位置。
+type Receipt struct {
+	TxID      string // 交易 ID（见 Transaction.ID）
+	BlockHash string // 所在区块的哈希
+	BlockIdx  int    // 所在区块的高度
+	TxIndex   int    // 在区块交易列表中的下标
+	Success   bool   // 是否执行成功（本演示中上链即成功）
+}
+
+// bloomBits 是每个区块布隆过滤器的位数（与以太坊 logsBloom 相同的 2048 位），
+// bloomHashes 是每个地址置位的哈希个数。
+const (
+	bloomBits   = 2048
+	bloomHashes = 3
+)
+
+// BloomFilter 是区块涉及地址的布隆过滤器：不存在漏报，但可能误报。
+type BloomFilter [bloomBits / 64]uint64
+
+// bloomPositions 从地址的 SHA-256 摘要中切出 bloomHashes 个位下标。
+func bloomPositions(address string) [bloomHashes]int {
+	sum := sha256.Sum256([]byte(address))
+	var pos [bloomHashes]int
+	for i := range pos {
+		// 每次取摘要中相邻的两个字节组成 16 位数，对位数取模（即取低 11 位）
+		pos[i] = (int(sum[2*i])<<8 | int(sum[2*i+1])) % bloomBits
+	}
+	return pos
+}
+
+// Add 把一个地址加入过滤器。
+func (f *BloomFilter) Add(address string) {
+	for _, p := range bloomPositions(address) {
+		f[p/64] |= 1 << (p % 64)
+	}
+}
+
+// MightContain 判断地址是否可能在过滤器中；返回 false 时一定不在。
+func (f *BloomFilter) MightContain(address string) bool {
+	for _, p := range bloomPositions(address) {
+		if f[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // Block 表示一个区块，包括索引、高度、时间戳、前一区块哈希、
 // 当前区块哈希、工作量证明用的 nonce，以及打包的交易列表。
 type Block struct {
-	Index        int           // 区块高度，从 0 开始
-	Timestamp    int64         // 区块生产的 Unix 时间戳（秒）
-	PrevHash     string        // 前一个区块的哈希（创世区块为空串或固定值）
-	Hash         string        // 当前区块的哈希（满足难度目标）
-	Nonce        int64         // 挖矿过程中尝试的计数器
-	Transactions []Transaction // 该区块包含的交易
+	Index           int           // 区块高度，从 0 开始
+	Timestamp       int64         // 区块生产的 Unix 时间戳（秒）
+	PrevHash        string        // 前一个区块的哈希（创世区块为空串或固定值）
+	Hash            string        // 当前区块的哈希（满足难度目标）
+	Nonce           int64         // 挖矿过程中尝试的计数器
+	Transactions    []Transaction // 该区块包含的交易
+	ValidatorPubKey []byte        // PoA：签名者公钥（参与哈希计算），PoW 区块为空
+	ValidatorSig    []byte        // PoA：签名者对 Hash 的签名，PoW 区块为空
+	Producer        string        // PoS：出块质押者地址（参与哈希计算），其他模式为空
 }
 
 // Blockchain 是链的容器，持有所有区块与全局难度设置。
 type Blockchain struct {
-	Blocks     []Block // 区块按顺序存放，Blocks[0] 是创世区块
-	Difficulty int     // 难度：要求哈希前缀有多少个 '0'（十六进制字符串）
+	Blocks               []Block                      // 区块按顺序存放，Blocks[0] 是创世区块
+	Difficulty           int                          // 难度：要求哈希前缀有多少个 '0'（十六进制字符串）
+	MaxTxAmount          int                          // 单笔交易金额上限，0 表示不限制
+	MaxAddressLen        int                          // 地址最大字节数，0 表示不限制
+	HashDisplayLen       int                          // 打印时哈希只显示前 N 个字符，0 表示完整显示（不影响存储值）
+	MaxMineAttempts      int64                        // AddBlock 单次挖矿最多尝试的 nonce 个数，0 表示不限制
+	Consensus            ConsensusMode                // 出块方式，默认 PoW
+	ValidatorKey         ed25519.PrivateKey           // PoA 模式下 AddBlock 用来签名的私钥
+	Authorities          []ed25519.PublicKey          // PoA 模式下允许出块的验证者公钥列表
+	RoundRobin           bool                         // PoA 模式下按高度轮流出块：高度 h 由 Authorities[h%len] 签名
+	TimestampGrid        int64                        // 区块时间戳须为该秒数的整数倍（AddBlock 向上取整），0 表示不限制
+	Shares               map[string]int               // 矿池演示：每个矿工被接受的份额数（见 SubmitShare）
+	shareTip             string                       // seenShares 对应的链尾哈希
+	seenShares           map[string]bool              // 当前链尾下已接受的份额哈希，用于去重
+	PoWSalt              string                       // 参与哈希计算的链专属盐，空串表示不加盐；要让创世块也加盐请用 NewSaltedBlockchain 创建链
+	DustThreshold        int                          // 低于该金额的交易视为粉尘并拒绝（铸币交易除外），0 表示不限制
+	UnspendableAddresses map[string]bool              // 不可花费的地址集合（如创世时铸给全零地址的“销毁”币）
+	MaxBlockBytes        int                          // 区块序列化字节数上限（见 Block.Size），0 表示不限制
+	BlockInterval        time.Duration                // 固定出块间隔（按整秒计），AddBlock 会等满间隔再出块，0 表示不等待
+	Clock                Clock                        // AddBlock 取时间戳、等待间隔以及校验未来时间戳所用的时钟，nil 表示系统时间
+	MaxFutureDrift       time.Duration                // 校验时拒绝时间戳晚于 Clock 当前时间加该偏差的区块，0 表示不检查
+	StrictTimestamps     bool                         // 为 true 时要求时间戳严格递增（>），默认允许与父块相等（>=）
+	StakerAddress        string                       // PoS 模式下本节点的质押地址，AddBlock 以它的身份出块
+	StakerKeys           map[string]ed25519.PublicKey // PoS 模式下质押地址到签名公钥的登记表
+	Slashed              map[string]int               // 被罚没的地址 -> 罚没生效高度（见 SubmitSlashProof），余额视为 0、不能转出、不再参与抽签
+	Attestations         map[int]map[string]bool      // 各高度已背书的验证者（见 Attest）
+	FinalizedAt          map[int]int                  // 各高度首次达到终局时的链尾高度
+	// CustomRules 是用户自定义的共识规则，AddBlock 与校验时对每个区块逐条执行，任一返回错误即拒绝该区块
+	CustomRules []func(b Block, prev Block, bc *Blockchain) error
 }
 
-// newGenesisBlock 创建创世区块（链的第一个区块）。
-func newGenesisBlock(difficulty int) Block {
+// newGenesisBlock 创建创世区块（链的第一个区块），allocs 是创世时的铸币交易，salt 为链的 PoWSalt。
+func newGenesisBlock(difficulty int, allocs []Transaction, salt string) (Block, error) {
 	// 创世区块的基础字段：索引为 0，时间戳为当前时间，PrevHash 设为固定值
 	b := Block{
 		Index:        0,
 		Timestamp:    time.Now().Unix(),
 		PrevHash:     "",
-		Transactions: []Transaction{}, // 创世区块可为空交易
+		Transactions: allocs, // 创世区块可为空交易
 	}
 	// 通过挖矿（PoW）求解一个满足难度的哈希
-	b.Hash, b.Nonce = mine(b, difficulty)
-	return b
+	var err error
+	b.Hash, b.Nonce, err = mine(b, difficulty, 0, salt)
+	return b, err
 }
 
-// newBlock 基于上一块创建新区块（未挖矿前先填充必要元数据）。
-func newBlock(prev Block, txs []Transaction) Block {
+// newBlock 基于上一块创建新区块（未挖矿前先填充必要元数据），timestamp 为出块时间（秒）。
+func newBlock(prev Block, txs []Transaction, timestamp int64) Block {
 	// 填写索引递增、时间戳、前哈希和交易等元数据
 	return Block{
 		Index:        prev.Index + 1,
-		Timestamp:    time.Now().Unix(),
+		Timestamp:    timestamp,
 		PrevHash:     prev.Hash,
 		Transactions: txs,
 	}
 }
 
+// BloomFilter 按需计算区块内所有付款方与收款方地址的布隆过滤器，
+// 轻客户端可据此跳过与自己无关的区块。
+func (b Block) BloomFilter() BloomFilter {
+	var f BloomFilter
+	for _, tx := range b.Transactions {
+		f.Add(tx.From)
+		f.Add(tx.To)
+	}
+	return f
+}
+
+// MightContain 判断区块是否可能包含与该地址相关的交易；返回 false 时一定不包含。
+func (b Block) MightContain(address string) bool {
+	f := b.BloomFilter()
+	return f.MightContain(address)
+}
+
+// MedianAmount 返回区块内普通交易金额的中位数；偶数笔时取中间两笔的平均值（整数除法）。
+// 与 BusiestBlock 一样不计铸币交易，没有普通交易时返回 0。
+func (b Block) MedianAmount() int {
+	var amounts []int
+	for _, tx := range b.Transactions {
+		if tx.From != "" {
+			amounts = append(amounts, tx.Amount)
+		}
+	}
+	n := len(amounts)
+	if n == 0 {
+		return 0
+	}
+	sort.Ints(amounts)
+	if n%2 == 1 {
+		return amounts[n/2]
+	}
+	return (amounts[n/2-1] + amounts[n/2]) / 2
+}
+
+// AchievedDifficulty 返回区块哈希实际拥有的前导 '0' 十六进制字符数，
+// 运气好时会超过挖矿时的目标难度。
+func (b Block) AchievedDifficulty() int {
+	return len(b.Hash) - len(strings.TrimLeft(b.Hash, "0"))
+}
+
 // serializeTransactions 把交易列表稳定地序列化为字节流，确保哈希可复现。
+// nil 与空切片都序列化为零字节，因此两者的区块哈希与校验结果完全相同。
 func serializeTransactions(txs []Transaction) []byte {
 	// 使用 bytes.Buffer 高效拼接字节
 	var buf bytes.Buffer
@@ -73,13 +276,27 @@ func serializeTransactions(txs []Transaction) []byte {
 		buf.WriteString(tx.To)
 		buf.WriteByte('|')
 		buf.WriteString(strconv.Itoa(tx.Amount))
+		// 只有带脚本的交易才写入脚本，保证无脚本交易的序列化不变
+		if len(tx.Script) > 0 {
+			buf.WriteByte('|')
+			buf.WriteString(hex.EncodeToString(tx.Script))
+		}
 		buf.WriteByte('\n')
 	}
 	return buf.Bytes()
 }
 
 // calculateHash 计算一个区块（当前 nonce 下）对应的哈希。
-func calculateHash(b Block) string {
+// salt 为链的 PoWSalt，非空时参与哈希，使不同用途的链无法共用挖矿结果。
+func calculateHash(b Block, salt string) string {
+	// 对拼接后的字节做一次 SHA-256，得到 32 字节摘要
+	sum := sha256.Sum256(blockPreimage(b, salt))
+	// 把摘要转为十六进制字符串，便于展示与比较前缀
+	return hex.EncodeToString(sum[:])
+}
+
+// blockPreimage 生成计算区块哈希所用的字节串。
+func blockPreimage(b Block, salt string) []byte {
 	// 将区块关键字段按固定顺序拼接成字节，确保同一内容哈希一致
 	var buf bytes.Buffer
 	buf.WriteString(strconv.Itoa(b.Index))
@@ -91,47 +308,459 @@ func calculateHash(b Block) string {
 	buf.Write(serializeTransactions(b.Transactions))
 	buf.WriteByte('|')
 	buf.WriteString(strconv.FormatInt(b.Nonce, 10))
+	// PoA/PoS 区块额外承诺签名者公钥与出块者；PoW 区块没有这些字段，哈希保持不变
+	if len(b.ValidatorPubKey) > 0 {
+		buf.WriteByte('|')
+		buf.WriteString(hex.EncodeToString(b.ValidatorPubKey))
+	}
+	if b.Producer != "" {
+		buf.WriteByte('|')
+		buf.WriteString(b.Producer)
+	}
+	// 未设置盐时不写入任何字节，保证旧链的哈希不变
+	if salt != "" {
+		buf.WriteByte('|')
+		buf.WriteString(salt)
+	}
+	return buf.Bytes()
+}
 
-	// 对拼接后的字节做一次 SHA-256，得到 32 字节摘要
-	sum := sha256.Sum256(buf.Bytes())
-	// 把摘要转为十六进制字符串，便于展示与比较前缀
-	return hex.EncodeToString(sum[:])
+// Size 估算区块序列化后的字节数：哈希原像（不含链的盐）加上存储的哈希与签名。
+func (b Block) Size() int {
+	return len(blockPreimage(b, "")) + len(b.Hash) + len(b.ValidatorSig)
+}
+
+// checkDifficulty 确认难度落在 [0, hashHexLen] 区间内，否则挖矿永远不会结束。
+func checkDifficulty(difficulty int) error {
+	if difficulty < 0 || difficulty > hashHexLen {
+		return fmt.Errorf("%w: %d not in [0, %d]", ErrImpossibleDifficulty, difficulty, hashHexLen)
+	}
+	return nil
 }
 
 // mine 执行工作量证明：不断尝试 nonce，直到哈希满足难度前缀。
-func mine(b Block, difficulty int) (hash string, nonce int64) {
+// 难度不可能达成时立即返回 ErrImpossibleDifficulty，而不是死循环；
+// maxAttempts 大于 0 时，尝试这么多次仍未成功则返回 ErrMiningGaveUp；salt 见 calculateHash。
+func mine(b Block, difficulty int, maxAttempts int64, salt string) (hash string, nonce int64, err error) {
+	if err := checkDifficulty(difficulty); err != nil {
+		return "", 0, err
+	}
 	// 目标前缀由 difficulty 个 '0' 组成（十六进制字符），例如难度 4 => "0000"
 	targetPrefix := strings.Repeat("0", difficulty)
 	// 从 0 开始尝试 nonce 递增
 	for {
+		if maxAttempts > 0 && nonce >= maxAttempts {
+			return "", 0, fmt.Errorf("%w: %d attempts", ErrMiningGaveUp, maxAttempts)
+		}
 		b.Nonce = nonce
-		h := calculateHash(b)
+		h := calculateHash(b, salt)
 		// 判断哈希是否以足够数量的 '0' 开头
 		if strings.HasPrefix(h, targetPrefix) {
-			return h, nonce // 满足条件，返回哈希与对应 nonce
+			return h, nonce, nil // 满足条件，返回哈希与对应 nonce
 		}
 		nonce++ // 不满足则继续尝试
 	}
 }
 
+// validateTransaction 检查将被打包在高度 height 的单笔交易是否满足链上配置的规则；
+// 出块前与 validateBlock 重放已有区块时共用同一套规则。
+func (bc *Blockchain) validateTransaction(tx Transaction, height int) error {
+	// 铸币交易只允许出现在创世区块中
+	if tx.From == "" {
+		return ErrMintOutsideGenesis
+	}
+	// 配置了单笔上限时，金额不得超过该上限（等于上限是允许的）
+	if bc.MaxTxAmount > 0 && tx.Amount > bc.MaxTxAmount {
+		return fmt.Errorf("%w: %d > %d", ErrAmountTooLarge, tx.Amount, bc.MaxTxAmount)
+	}
+	// 配置了粉尘门槛时，金额低于门槛的交易被拒绝（等于门槛是允许的）；铸币已在上面拒绝
+	if bc.DustThreshold > 0 && tx.Amount < bc.DustThreshold {
+		return fmt.Errorf("%w: %d < %d", ErrDustOutput, tx.Amount, bc.DustThreshold)
+	}
+	// 付款方与收款方地址都要通过格式检查
+	for _, addr := range []string{tx.From, tx.To} {
+		if err := bc.validateAddress(addr); err != nil {
+			return err
+		}
+	}
+	// 不可花费地址里的币永远不能转出
+	if bc.UnspendableAddresses[tx.From] {
+		return fmt.Errorf("%w: %q", ErrUnspendableAddress, tx.From)
+	}
+	// 被罚没的地址余额已清零，罚没生效后不能再转出（生效前的历史交易仍然有效）
+	if bc.slashedAt(tx.From, height) {
+		return fmt.Errorf("%w: %q", ErrSlashedAddress, tx.From)
+	}
+	// 带脚本的交易必须执行成功
+	if len(tx.Script) > 0 {
+		return runScript(tx.Script)
+	}
+	return nil
+}
+
+// validateAddress 拒绝超长或含控制字符的地址，避免打印与存储时出现问题。
+func (bc *Blockchain) validateAddress(addr string) error {
+	if bc.MaxAddressLen > 0 && len(addr) > bc.MaxAddressLen {
+		return fmt.Errorf("%w: length %d > %d", ErrInvalidAddress, len(addr), bc.MaxAddressLen)
+	}
+	for _, r := range addr {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: control character %q", ErrInvalidAddress, r)
+		}
+	}
+	return nil
+}
+
 // AddBlock 把一组交易打包成区块、挖矿并加入链尾。
-func (bc *Blockchain) AddBlock(txs []Transaction) Block {
+// 任意一笔交易不合规时返回错误，链保持不变。
+func (bc *Blockchain) AddBlock(txs []Transaction) (Block, error) {
+	return bc.AddBlockContext(context.Background(), txs)
+}
+
+// AddBlockContext 与 AddBlock 相同，但在配置了 BlockInterval 时会等待出块间隔，
+// 等待期间 ctx 被取消则返回 ctx.Err()，链保持不变。
+func (bc *Blockchain) AddBlockContext(ctx context.Context, txs []Transaction) (Block, error) {
+	// 先逐笔校验交易，避免把不合规的交易打包进区块
+	for _, tx := range txs {
+		if err := bc.validateTransaction(tx, len(bc.Blocks)); err != nil {
+			return Block{}, err
+		}
+	}
 	// 取当前链的最后一个区块作为父块
 	prev := bc.Blocks[len(bc.Blocks)-1]
-	// 先构造未挖矿的新块（包含元数据与交易）
-	b := newBlock(prev, txs)
-	// 进行 PoW，得到满足难度的哈希与 nonce
-	h, n := mine(b, bc.Difficulty)
-	b.Hash = h
-	b.Nonce = n
+	// 先构造未挖矿的新块（包含元数据与交易），时间取自链的时钟
+	clock := bc.clock()
+	b := newBlock(prev, txs, clock.Now().Unix())
+	// 配置了固定出块间隔时，等到距上一块满一个间隔；若早已超过则不等待，
+	// 时间戳取“当前时间”与“上一块 + 间隔”中较晚者，既不早于间隔也不会落在过去
+	if secs := int64(bc.BlockInterval / time.Second); secs > 0 {
+		target := prev.Timestamp + secs
+		if err := waitUntil(ctx, clock, time.Unix(target, 0)); err != nil {
+			return Block{}, err
+		}
+		b.Timestamp = max(clock.Now().Unix(), target)
+	}
+	// 严格递增模式下，同一秒内连续出块时把时间戳顺延到父块之后一秒
+	if bc.StrictTimestamps {
+		b.Timestamp = max(b.Timestamp, prev.Timestamp+1)
+	}
+	// 配置了时间网格时，从“不早于父块”的时刻起向上对齐到网格，
+	// 保证时间戳既在网格上、不早于父块，也不会缩短上面的出块间隔
+	if g := bc.TimestampGrid; g > 0 {
+		ts := max(b.Timestamp, prev.Timestamp)
+		if r := ts % g; r != 0 {
+			ts += g - r
+		}
+		b.Timestamp = ts
+	}
+	switch bc.Consensus {
+	case PoA:
+		// PoA：由配置的验证者签名，不需要挖矿
+		if err := bc.signBlock(&b); err != nil {
+			return Block{}, err
+		}
+	case PoS:
+		// PoS：仅当本节点被抽中时才能签名出块
+		if err := bc.stakeBlock(&b); err != nil {
+			return Block{}, err
+		}
+	default:
+		// 进行 PoW，得到满足难度的哈希与 nonce
+		h, n, err := mine(b, bc.Difficulty, bc.MaxMineAttempts, bc.PoWSalt)
+		if err != nil {
+			return Block{}, err
+		}
+		b.Hash = h
+		b.Nonce = n
+	}
+	// 追加前检查区块大小并执行自定义规则，不通过则链保持不变
+	if err := bc.checkBlockSize(b); err != nil {
+		return Block{}, err
+	}
+	if err := bc.checkCustomRules(b, prev); err != nil {
+		return Block{}, err
+	}
 	// 将新块追加到链上
 	bc.Blocks = append(bc.Blocks, b)
-	return b
+	return b, nil
+}
+
+// Clock 是 AddBlock 使用的时间来源，测试中可替换为假时钟。
+type Clock interface {
+	Now() time.Time                         // 当前时间
+	After(d time.Duration) <-chan time.Time // d 之后触发的通道，语义同 time.After
+}
+
+// realClock 是基于系统时间的默认时钟。
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clock 返回链配置的时钟，未配置时使用系统时间。
+func (bc *Blockchain) clock() Clock {
+	if bc.Clock != nil {
+		return bc.Clock
+	}
+	return realClock{}
+}
+
+// waitUntil 按 clock 阻塞到时刻 t（已过去则立即返回），ctx 先被取消时返回 ctx.Err()。
+func waitUntil(ctx context.Context, clock Clock, t time.Time) error {
+	d := t.Sub(clock.Now())
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// validateBlock 校验单个区块相对于其父块是否成立，
+// 返回包装了 ErrInvalidBlock 的错误说明失败原因。
+func (bc *Blockchain) validateBlock(cur, prev Block) error {
+	// 1) 前哈希要匹配
+	if cur.PrevHash != prev.Hash {
+		return fmt.Errorf("%w: block %d prev hash mismatch", ErrInvalidBlock, cur.Index)
+	}
+	// 2) 重新计算当前块哈希，必须等于记录值
+	if calculateHash(cur, bc.PoWSalt) != cur.Hash {
+		return fmt.Errorf("%w: block %d hash mismatch", ErrInvalidBlock, cur.Index)
+	}
+	// 3) 时间戳不得早于父块（严格模式下也不得相等）；配置了时间网格时，还必须落在网格上
+	if cur.Timestamp < prev.Timestamp {
+		return fmt.Errorf("%w: block %d timestamp %d before parent %d", ErrInvalidBlock, cur.Index, cur.Timestamp, prev.Timestamp)
+	}
+	if bc.StrictTimestamps && cur.Timestamp == prev.Timestamp {
+		return fmt.Errorf("%w: block %d timestamp %d equals parent", ErrInvalidBlock, cur.Index, cur.Timestamp)
+	}
+	if bc.TimestampGrid > 0 && cur.Timestamp%bc.TimestampGrid != 0 {
+		return fmt.Errorf("%w: block %d timestamp %d not on %ds grid", ErrInvalidBlock, cur.Index, cur.Timestamp, bc.TimestampGrid)
+	}
+	// 配置了未来偏差上限时，时间戳不得超前于校验者时钟太多
+	if bc.MaxFutureDrift > 0 {
+		if err := checkFutureDrift(cur, bc.clock().Now().Add(bc.MaxFutureDrift)); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+		}
+	}
+	// 4) 区块大小不得超过上限
+	if err := bc.checkBlockSize(cur); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+	// 5) 每笔交易都要满足出块时的交易规则（不得铸币、金额上限、粉尘、不可花费地址、脚本等）
+	for i, tx := range cur.Transactions {
+		if err := bc.validateTransaction(tx, cur.Index); err != nil {
+			return fmt.Errorf("%w: block %d tx %d: %w", ErrInvalidBlock, cur.Index, i, err)
+		}
+	}
+	// 6) 自定义共识规则
+	if err := bc.checkCustomRules(cur, prev); err != nil {
+		return err
+	}
+	// 7) PoA 需由授权验证者签名；PoS 需由抽中的质押者签名；PoW 需满足难度前缀
+	switch bc.Consensus {
+	case PoA:
+		return bc.verifyAuthority(cur)
+	case PoS:
+		return bc.verifyStaker(cur)
+	}
+	if !strings.HasPrefix(cur.Hash, strings.Repeat("0", bc.Difficulty)) {
+		return fmt.Errorf("%w: block %d does not meet difficulty %d", ErrInvalidBlock, cur.Index, bc.Difficulty)
+	}
+	return nil
+}
+
+// checkFutureDrift 检查区块时间戳不晚于 limit（等于 limit 是允许的）。
+func checkFutureDrift(b Block, limit time.Time) error {
+	if time.Unix(b.Timestamp, 0).After(limit) {
+		return fmt.Errorf("%w: block %d at %d, limit %d", ErrFutureBlock, b.Index, b.Timestamp, limit.Unix())
+	}
+	return nil
+}
+
+// checkBlockSize 检查区块字节数不超过 MaxBlockBytes（等于上限是允许的）。
+func (bc *Blockchain) checkBlockSize(b Block) error {
+	if size := b.Size(); bc.MaxBlockBytes > 0 && size > bc.MaxBlockBytes {
+		return fmt.Errorf("%w: block %d is %d bytes > %d", ErrBlockTooLarge, b.Index, size, bc.MaxBlockBytes)
+	}
+	return nil
+}
+
+// checkCustomRules 依次执行 CustomRules，返回第一条失败规则的错误（同时包装 ErrInvalidBlock）。
+func (bc *Blockchain) checkCustomRules(b, prev Block) error {
+	for _, rule := range bc.CustomRules {
+		if err := rule(b, prev, bc); err != nil {
+			return fmt.Errorf("%w: block %d custom rule: %w", ErrInvalidBlock, b.Index, err)
+		}
+	}
+	return nil
+}
+
+// signBlock 在 PoA 模式下检查本节点有权出块后，用 ValidatorKey 封装区块。
+// 自身不在授权列表中时拒绝出块，避免把链延伸成无效状态。
+func (bc *Blockchain) signBlock(b *Block) error {
+	if len(bc.ValidatorKey) != ed25519.PrivateKeySize {
+		return ErrNoValidatorKey
+	}
+	pub := bc.ValidatorKey.Public().(ed25519.PublicKey)
+	if !bc.isAuthority(pub) {
+		return fmt.Errorf("%w: configured validator key", ErrUnauthorizedValidator)
+	}
+	if bc.RoundRobin && !bytes.Equal(pub, bc.ScheduledAuthority(b.Index)) {
+		return fmt.Errorf("%w: height %d", ErrOutOfTurn, b.Index)
+	}
+	bc.sealBlock(b)
+	return nil
+}
+
+// sealBlock 用 ValidatorKey 封装区块：写入公钥、计算哈希并对哈希签名（PoA 与 PoS 共用）。
+func (bc *Blockchain) sealBlock(b *Block) {
+	b.ValidatorPubKey = bc.ValidatorKey.Public().(ed25519.PublicKey)
+	b.Hash = calculateHash(*b, bc.PoWSalt)
+	b.ValidatorSig = ed25519.Sign(bc.ValidatorKey, []byte(b.Hash))
+}
+
+// verifyAuthority 检查区块签名者在授权列表中，且签名确实是对区块哈希的有效签名。
+func (bc *Blockchain) verifyAuthority(b Block) error {
+	if !bc.isAuthority(b.ValidatorPubKey) {
+		return fmt.Errorf("%w: block %d signer not in authorities", ErrUnauthorizedValidator, b.Index)
+	}
+	if bc.RoundRobin && !bytes.Equal(b.ValidatorPubKey, bc.ScheduledAuthority(b.Index)) {
+		return fmt.Errorf("%w: block %d", ErrOutOfTurn, b.Index)
+	}
+	if !ed25519.Verify(b.ValidatorPubKey, []byte(b.Hash), b.ValidatorSig) {
+		return fmt.Errorf("%w: block %d bad signature", ErrUnauthorizedValidator, b.Index)
+	}
+	return nil
+}
+
+// ScheduledAuthority 返回轮流出块模式下高度 height 应由哪个验证者签名；
+// 没有配置验证者时返回 nil。
+func (bc *Blockchain) ScheduledAuthority(height int) ed25519.PublicKey {
+	if len(bc.Authorities) == 0 {
+		return nil
+	}
+	return bc.Authorities[height%len(bc.Authorities)]
+}
+
+// isAuthority 判断公钥是否在 Authorities 授权列表中。
+func (bc *Blockchain) isAuthority(pub []byte) bool {
+	for _, a := range bc.Authorities {
+		if bytes.Equal(a, pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// FirstInvalidBlock 返回第一个校验失败的区块在链中的位置；全部有效时 found 为 false。
+func (bc *Blockchain) FirstInvalidBlock() (index int, found bool) {
+	// 从第 1 个区块开始（跳过创世块），逐一检查
+	for i := 1; i < len(bc.Blocks); i++ {
+		if bc.validateBlock(bc.Blocks[i], bc.Blocks[i-1]) != nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// ValidationProgress 是流式校验的进度：已校验到的高度、目前是否全部有效，
+// 以及是否为最终结果（Done）。校验失败时 Err 说明原因。
+type ValidationProgress struct {
+	Height int   // 刚校验完的区块高度
+	Total  int   // 链上区块总数
+	Valid  bool  // 截至 Height 是否全部有效
+	Done   bool  // 是否为最后一条（最终结果）
+	Err    error // 校验失败的原因，有效时为 nil
+}
+
+// ValidateStream 在后台逐块校验链，并通过通道推送进度；最后一条的 Done 为 true，
+// 其 Valid 与 IsValid 的结果一致，随后通道关闭。
+// ctx 被取消时停止校验并直接关闭通道，不再推送最终结果。
+func (bc *Blockchain) ValidateStream(ctx context.Context) <-chan ValidationProgress {
+	ch := make(chan ValidationProgress)
+	blocks := bc.Blocks // 取快照，避免校验过程中链被追加
+	go func() {
+		defer close(ch)
+		// send 在推送与取消之间择一，返回 false 表示已取消
+		send := func(p ValidationProgress) bool {
+			select {
+			case ch <- p:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for i := 1; i < len(blocks); i++ {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := bc.validateBlock(blocks[i], blocks[i-1]); err != nil {
+				send(ValidationProgress{Height: i, Total: len(blocks), Done: true, Err: err})
+				return
+			}
+			if !send(ValidationProgress{Height: i, Total: len(blocks), Valid: true}) {
+				return
+			}
+		}
+		send(ValidationProgress{Height: len(blocks) - 1, Total: len(blocks), Valid: true, Done: true})
+	}()
+	return ch
+}
+
+// ValidateWithSkew 完整校验整条链，并额外拒绝时间戳晚于“本机当前时间 + maxSkew”的区块，
+// 允许时钟略有偏差的节点接受稍微超前的区块。当前时间取自链的 Clock。返回第一个失败区块的错误。
+// 同时配置了 MaxFutureDrift 时两者都生效，即以较严者为准。
+func (bc *Blockchain) ValidateWithSkew(maxSkew time.Duration) error {
+	limit := bc.clock().Now().Add(maxSkew)
+	for i, b := range bc.Blocks {
+		if err := checkFutureDrift(b, limit); err != nil {
+			return err
+		}
+		if i == 0 {
+			continue // 创世块没有父块，只检查时间戳
+		}
+		if err := bc.validateBlock(b, bc.Blocks[i-1]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // IsValid 校验整条链的一致性与工作量证明是否成立。
 func (bc *Blockchain) IsValid() bool {
-	// 从第 1 个区块开始（跳过创世块），逐一检查
+	_, found := bc.FirstInvalidBlock()
+	return !found // 没有找到无效区块，链有效
+}
+
+// Receipt 按交易 ID 查找已上链交易的回执；找不到时返回 false。
+// 若有多笔相同内容的交易，返回最早上链的那一笔。
+func (bc *Blockchain) Receipt(txID string) (*Receipt, bool) {
+	// 从创世块向后逐块、逐笔比对交易 ID
+	for _, b := range bc.Blocks {
+		for i, tx := range b.Transactions {
+			if tx.ID() == txID {
+				return &Receipt{
+					TxID:      txID,
+					BlockHash: b.Hash,
+					BlockIdx:  b.Index,
+					TxIndex:   i,
+					Success:   true,
+				}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// QuickCheck 快速抽查链：只检查前哈希链接，以及记录的哈希是否满足难度前缀，
+// 不重新计算哈希（即信任 Hash 字段）。
+// 注意：它无法发现交易或其他区块字段被篡改，完整校验请用 IsValid。
+func (bc *Blockchain) QuickCheck() bool {
+	targetPrefix := strings.Repeat("0", bc.Difficulty)
 	for i := 1; i < len(bc.Blocks); i++ {
 		cur := bc.Blocks[i]
 		prev := bc.Blocks[i-1]
@@ -139,36 +768,716 @@ func (bc *Blockchain) IsValid() bool {
 		if cur.PrevHash != prev.Hash {
 			return false
 		}
-		// 2) 重新计算当前块哈希，必须等于记录值
-		if calculateHash(cur) != cur.Hash {
+		// 2) 记录的哈希需满足难度前缀（不重算）；PoA、PoS 区块不挖矿，跳过此项
+		if bc.Consensus == PoW && !strings.HasPrefix(cur.Hash, targetPrefix) {
 			return false
 		}
-		// 3) 哈希需满足难度前缀
-		if !strings.HasPrefix(cur.Hash, strings.Repeat("0", bc.Difficulty)) {
-			return false
+	}
+	return true
+}
+
+// TimestampSeries 按高度顺序返回每个区块的时间戳，便于画图。
+func (bc *Blockchain) TimestampSeries() []int64 {
+	series := make([]int64, len(bc.Blocks))
+	for i, b := range bc.Blocks {
+		series[i] = b.Timestamp
+	}
+	return series
+}
+
+// Intervals 返回相邻两个区块之间的出块间隔，长度为区块数减一；
+// 只有创世块时返回空切片。
+func (bc *Blockchain) Intervals() []time.Duration {
+	if len(bc.Blocks) < 2 {
+		return []time.Duration{}
+	}
+	gaps := make([]time.Duration, len(bc.Blocks)-1)
+	for i := 1; i < len(bc.Blocks); i++ {
+		// 时间戳单位为秒，换算成 time.Duration
+		gaps[i-1] = time.Duration(bc.Blocks[i].Timestamp-bc.Blocks[i-1].Timestamp) * time.Second
+	}
+	return gaps
+}
+
+// BlockMetric 是单个区块的统计指标，供图表前端一次取用。
+type BlockMetric struct {
+	Height      int           // 区块高度
+	Timestamp   int64         // 区块时间戳（秒）
+	TxCount     int           // 交易笔数
+	TotalAmount int           // 交易总金额
+	Nonce       int64         // 挖矿得到的 nonce
+	Difficulty  int           // 难度（本链为全局难度，各区块相同）
+	Interval    time.Duration // 与上一区块的时间间隔，创世区块为 0
+}
+
+// BlockMetrics 单次遍历生成每个区块的统计指标。
+func (bc *Blockchain) BlockMetrics() []BlockMetric {
+	metrics := make([]BlockMetric, len(bc.Blocks))
+	for i, b := range bc.Blocks {
+		m := BlockMetric{
+			Height:     b.Index,
+			Timestamp:  b.Timestamp,
+			TxCount:    len(b.Transactions),
+			Nonce:      b.Nonce,
+			Difficulty: bc.Difficulty,
+		}
+		for _, tx := range b.Transactions {
+			m.TotalAmount += tx.Amount
+		}
+		if i > 0 {
+			m.Interval = time.Duration(b.Timestamp-bc.Blocks[i-1].Timestamp) * time.Second
+		}
+		metrics[i] = m
+	}
+	return metrics
+}
+
+// SpendableBalance 计算地址可花费的余额（被罚没的地址恒为 0）：只计入确认数不少于 minConfirmations 的转入，
+// 但扣除全部转出（保守算法，未确认的支出同样扣减）。
+// 确认数按“最新区块高度 - 所在区块高度 + 1”计算，最新区块自身有 1 个确认。
+func (bc *Blockchain) SpendableBalance(address string, minConfirmations int) int {
+	// 被罚没的地址没有可花费余额
+	if _, slashed := bc.Slashed[address]; slashed {
+		return 0
+	}
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	balance := 0
+	for _, b := range bc.Blocks {
+		confirmations := tip.Index - b.Index + 1
+		for _, tx := range b.Transactions {
+			// 转出一律扣减
+			if tx.From == address {
+				balance -= tx.Amount
+			}
+			// 转入需要埋得足够深才计入
+			if tx.To == address && confirmations >= minConfirmations {
+				balance += tx.Amount
+			}
+		}
+	}
+	return balance
+}
+
+// ReorgProbability 按中本聪白皮书第 11 节的泊松近似公式，计算攻击者（占全网算力
+// attackerHashFraction）在交易已有 depth 个确认后仍能追上并重组的概率。
+// 攻击者算力不少于一半时必然成功，返回 1。
+func ReorgProbability(depth int, attackerHashFraction float64) float64 {
+	q := attackerHashFraction
+	p := 1 - q
+	if q <= 0 {
+		return 0
+	}
+	if q >= p {
+		return 1
+	}
+	z := float64(depth)
+	// 诚实节点挖出 z 个块期间，攻击者预期挖出的块数
+	lambda := z * (q / p)
+	sum := 1.0
+	poisson := math.Exp(-lambda) // k = 0 时的泊松概率
+	for k := 0; k <= depth; k++ {
+		if k > 0 {
+			poisson *= lambda / float64(k)
+		}
+		// 减去“攻击者挖了 k 块且之后追不上”的概率
+		sum -= poisson * (1 - math.Pow(q/p, z-float64(k)))
+	}
+	return sum
+}
+
+// txIDs 收集链上所有交易的 ID 集合。
+func (bc *Blockchain) txIDs() map[string]bool {
+	ids := make(map[string]bool)
+	for _, b := range bc.Blocks {
+		for _, tx := range b.Transactions {
+			ids[tx.ID()] = true
+		}
+	}
+	return ids
+}
+
+// TxDiff 按交易 ID 比较两条链，返回只出现在 a 中和只出现在 b 中的交易（各自按链上顺序、去重）。
+// 分叉重组后可据此把被丢弃的交易重新放回待打包列表。
+func TxDiff(a, b *Blockchain) (onlyA, onlyB []Transaction) {
+	return a.txsNotIn(b.txIDs()), b.txsNotIn(a.txIDs())
+}
+
+// txsNotIn 返回本链中 ID 不在 exclude 里的交易，同一 ID 只返回一次。
+func (bc *Blockchain) txsNotIn(exclude map[string]bool) []Transaction {
+	var out []Transaction
+	seen := make(map[string]bool)
+	for _, b := range bc.Blocks {
+		for _, tx := range b.Transactions {
+			id := tx.ID()
+			if exclude[id] || seen[id] {
+				continue
+			}
+			seen[id] = true
+			out = append(out, tx)
+		}
+	}
+	return out
+}
+
+// MedianAmountSeries 按高度顺序返回每个区块的普通交易金额中位数（见 MedianAmount）。
+func (bc *Blockchain) MedianAmountSeries() []int {
+	series := make([]int, len(bc.Blocks))
+	for i, b := range bc.Blocks {
+		series[i] = b.MedianAmount()
+	}
+	return series
+}
+
+// Balances 重放整条链，返回各地址当前余额；被罚没的地址余额记为 0。
+func (bc *Blockchain) Balances() map[string]int {
+	balances := bc.SimulateBalances(nil)
+	for addr := range bc.Slashed {
+		if _, ok := balances[addr]; ok {
+			balances[addr] = 0
+		}
+	}
+	return balances
+}
+
+// WealthGini 计算所有地址余额的基尼系数，取值 0（完全平均）到 1（高度集中）。
+// 演示链不强制余额非负，负余额按 0 计；地址不足两个或总额为 0 时返回 0。
+func (bc *Blockchain) WealthGini() float64 {
+	balances := bc.Balances()
+	values := make([]float64, 0, len(balances))
+	total := 0.0
+	for _, v := range balances {
+		x := float64(max(v, 0))
+		values = append(values, x)
+		total += x
+	}
+	n := float64(len(values))
+	if len(values) < 2 || total == 0 {
+		return 0
+	}
+	// 升序排序后套用公式 G = 2·Σ(i·x_i)/(n·Σx) - (n+1)/n，i 从 1 开始
+	sort.Float64s(values)
+	weighted := 0.0
+	for i, x := range values {
+		weighted += float64(i+1) * x
+	}
+	return 2*weighted/(n*total) - (n+1)/n
+}
+
+// ExportBalances 把当前余额表以 JSON 对象（地址 -> 余额）写出，可用于快照空投。
+// 演示链不强制余额非负，含负余额的表无法被 GenesisFromBalances 还原。
+func (bc *Blockchain) ExportBalances(w io.Writer) error {
+	return json.NewEncoder(w).Encode(bc.Balances())
+}
+
+// SimulateBalances 假设只有通过 filter 的交易被打包，从创世块重放整条链，
+// 返回各地址的余额（付款方减、收款方加），不会修改链本身。filter 为 nil 时重放全部交易。
+func (bc *Blockchain) SimulateBalances(filter func(Transaction) bool) map[string]int {
+	return replayBalances(bc.Blocks, filter)
+}
+
+// replayBalances 依次重放 blocks 中通过 filter 的交易，返回各地址余额。
+func replayBalances(blocks []Block, filter func(Transaction) bool) map[string]int {
+	balances := make(map[string]int)
+	for _, b := range blocks {
+		for _, tx := range b.Transactions {
+			if filter != nil && !filter(tx) {
+				continue
+			}
+			// 铸币交易没有付款方，只给收款方加钱
+			if tx.From != "" {
+				balances[tx.From] -= tx.Amount
+			}
+			balances[tx.To] += tx.Amount
+		}
+	}
+	return balances
+}
+
+// 按金额分档推荐的确认数：小额、中额、大额。
+const (
+	smallAmountConfirmations  = 1
+	mediumAmountConfirmations = 3
+	largeAmountConfirmations  = 6
+)
+
+// ConfirmationsForAmount 按金额推荐等待的确认数：低于 smallThreshold 为小额，
+// 不低于 largeThreshold 为大额，其余为中额；金额越大，需要的确认越多。
+func ConfirmationsForAmount(amount int, smallThreshold, largeThreshold int) int {
+	switch {
+	case amount < smallThreshold:
+		return smallAmountConfirmations
+	case amount >= largeThreshold:
+		return largeAmountConfirmations
+	default:
+		return mediumAmountConfirmations
+	}
+}
+
+// DegreeCentrality 统计每个地址交易过的不同对手方数量（转入与转出都算），
+// 数值越大越像资金枢纽。铸币交易没有付款方，不计入；给自己转账也不计入。
+func (bc *Blockchain) DegreeCentrality() map[string]int {
+	peers := make(map[string]map[string]bool)
+	link := func(a, b string) {
+		if peers[a] == nil {
+			peers[a] = make(map[string]bool)
+		}
+		peers[a][b] = true
+	}
+	for _, b := range bc.Blocks {
+		for _, tx := range b.Transactions {
+			if tx.From == "" || tx.From == tx.To {
+				continue
+			}
+			link(tx.From, tx.To)
+			link(tx.To, tx.From)
+		}
+	}
+	degree := make(map[string]int, len(peers))
+	for addr, set := range peers {
+		degree[addr] = len(set)
+	}
+	return degree
+}
+
+// suspiciousNonceFactor：nonce 低于期望尝试次数的这一分之一即视为可疑。
+// 诚实挖矿时出现这种情况的概率约为 1/suspiciousNonceFactor。
+const suspiciousNonceFactor = 1000
+
+// SuspiciousBlocks 返回 nonce 相对难度小得不合常理的区块高度
+// （期望尝试次数为 16^Difficulty），可能意味着伪造或低难度区块。
+// 这只是启发式的异常提示，并非共识规则；PoA 链不挖矿，始终返回空。
+func (bc *Blockchain) SuspiciousBlocks() []int {
+	var heights []int
+	if bc.Consensus != PoW {
+		return heights
+	}
+	threshold := math.Pow(16, float64(bc.Difficulty)) / suspiciousNonceFactor
+	for _, b := range bc.Blocks {
+		// nonce 从 0 开始，实际尝试次数为 nonce+1
+		if float64(b.Nonce+1) < threshold {
+			heights = append(heights, b.Index)
+		}
+	}
+	return heights
+}
+
+// MaxAchievedDifficulty 返回链上所有区块中最大的实际难度（见 Block.AchievedDifficulty）。
+func (bc *Blockchain) MaxAchievedDifficulty() int {
+	best := 0
+	for _, b := range bc.Blocks {
+		if d := b.AchievedDifficulty(); d > best {
+			best = d
+		}
+	}
+	return best
+}
+
+// IsValidShare 判断区块能否作为矿池份额：哈希与内容一致，且满足（通常低于区块难度的）份额难度。
+// 它按未加盐的哈希校验；设置了 PoWSalt 的链请使用 SubmitShare。
+func IsValidShare(b Block, shareDifficulty int) bool {
+	return isValidShare(b, shareDifficulty, "")
+}
+
+// isValidShare 是带盐版本的份额校验。
+func isValidShare(b Block, shareDifficulty int, salt string) bool {
+	if calculateHash(b, salt) != b.Hash {
+		return false
+	}
+	return strings.HasPrefix(b.Hash, strings.Repeat("0", shareDifficulty))
+}
+
+// SubmitShare 接受矿工 miner 提交的份额并计入 Shares；份额必须基于当前链尾，
+// 且同一链尾下同一份额（按哈希）只能被接受一次，重复提交返回错误。
+// 若份额同时满足区块难度，found 为 true，表示该矿工找到了一个可以出块的解。
+func (bc *Blockchain) SubmitShare(miner string, b Block, shareDifficulty int) (found bool, err error) {
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if b.PrevHash != tip.Hash {
+		return false, fmt.Errorf("%w: not built on current tip", ErrInvalidShare)
+	}
+	if !isValidShare(b, shareDifficulty, bc.PoWSalt) {
+		return false, fmt.Errorf("%w: does not meet share difficulty %d", ErrInvalidShare, shareDifficulty)
+	}
+	// 链尾变化后旧份额不可能再被提交，清空已接受集合
+	if bc.shareTip != tip.Hash {
+		bc.shareTip = tip.Hash
+		bc.seenShares = make(map[string]bool)
+	}
+	if bc.seenShares[b.Hash] {
+		return false, fmt.Errorf("%w: duplicate share %s", ErrInvalidShare, b.Hash)
+	}
+	bc.seenShares[b.Hash] = true
+	if bc.Shares == nil {
+		bc.Shares = make(map[string]int)
+	}
+	bc.Shares[miner]++
+	return strings.HasPrefix(b.Hash, strings.Repeat("0", bc.Difficulty)), nil
+}
+
+// ArchiveGroup 是若干连续区块的归档摘要，仅用于展示，不影响真实链。
+type ArchiveGroup struct {
+	StartHeight int    // 组内第一个区块的高度
+	EndHeight   int    // 组内最后一个区块的高度（含）
+	TxCount     int    // 组内交易总笔数
+	TotalAmount int    // 组内交易总金额
+	StartHash   string // 组内第一个区块的哈希
+	EndHash     string // 组内最后一个区块的哈希
+}
+
+// ArchiveSummary 把链按每 groupSize 个区块分组汇总，最后一组可能不足 groupSize；
+// groupSize <= 0 时返回 nil。
+func (bc *Blockchain) ArchiveSummary(groupSize int) []ArchiveGroup {
+	if groupSize <= 0 {
+		return nil
+	}
+	var groups []ArchiveGroup
+	for start := 0; start < len(bc.Blocks); start += groupSize {
+		end := min(start+groupSize, len(bc.Blocks)) - 1
+		g := ArchiveGroup{
+			StartHeight: bc.Blocks[start].Index,
+			EndHeight:   bc.Blocks[end].Index,
+			StartHash:   bc.Blocks[start].Hash,
+			EndHash:     bc.Blocks[end].Hash,
+		}
+		for _, b := range bc.Blocks[start : end+1] {
+			g.TxCount += len(b.Transactions)
+			for _, tx := range b.Transactions {
+				g.TotalAmount += tx.Amount
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// SenderCollisionProbability 计算从链上随机取两笔不同的交易，它们付款方相同的概率：
+// Σ n_i(n_i-1) / (N(N-1))，n_i 为各付款方的交易数，N 为交易总数。
+// 铸币交易没有付款方，不参与统计；交易不足两笔时返回 0。
+func (bc *Blockchain) SenderCollisionProbability() float64 {
+	counts := make(map[string]int)
+	total := 0
+	for _, b := range bc.Blocks {
+		for _, tx := range b.Transactions {
+			if tx.From == "" {
+				continue
+			}
+			counts[tx.From]++
+			total++
+		}
+	}
+	if total < 2 {
+		return 0
+	}
+	pairs := 0
+	for _, n := range counts {
+		pairs += n * (n - 1)
+	}
+	return float64(pairs) / float64(total*(total-1))
+}
+
+// StorageEfficiency 返回全链交易数据字节数占区块总字节数（见 Block.Size）的比例，
+// 越接近 1 说明区块头等开销越小；链为空时返回 0。
+func (bc *Blockchain) StorageEfficiency() float64 {
+	useful, total := 0, 0
+	for _, b := range bc.Blocks {
+		useful += len(serializeTransactions(b.Transactions))
+		total += b.Size()
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(useful) / float64(total)
+}
+
+// nonceEntropyBits 是统计熵时取 nonce 的低位位数，共 2^nonceEntropyBits 个桶。
+const nonceEntropyBits = 4
+
+// NonceEntropy 计算各区块 nonce 低 nonceEntropyBits 位分布的香农熵（单位：比特），
+// 公平随机的挖矿下随区块增多趋近上限 nonceEntropyBits；区块少于两个时返回 0。
+func (bc *Blockchain) NonceEntropy() float64 {
+	if len(bc.Blocks) < 2 {
+		return 0
+	}
+	var counts [1 << nonceEntropyBits]int
+	for _, b := range bc.Blocks {
+		counts[b.Nonce&(1<<nonceEntropyBits-1)]++
+	}
+	n := float64(len(bc.Blocks))
+	entropy := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// calibrationHashes 是 CalibrateDifficulty 测速时计算的哈希次数。
+const calibrationHashes = 20000
+
+// CalibrateDifficulty 测量本机算力，返回期望挖矿时间（16^d / 算力）不短于 minMineTime 的最小难度 d，
+// 用来在不同硬件上自动选取合适的演示难度；最大不超过哈希位数。
+func (bc *Blockchain) CalibrateDifficulty(minMineTime time.Duration) int {
+	// 用基于链尾的一个空块反复计算哈希以测速
+	b := newBlock(bc.Blocks[len(bc.Blocks)-1], nil, time.Now().Unix())
+	start := time.Now()
+	for i := 0; i < calibrationHashes; i++ {
+		b.Nonce = int64(i)
+		calculateHash(b, bc.PoWSalt)
+	}
+	elapsed := max(time.Since(start), time.Nanosecond)
+	hashesPerSecond := calibrationHashes / elapsed.Seconds()
+	// 从 0 开始逐级提高难度，直到期望耗时达到要求
+	for d := 0; d < hashHexLen; d++ {
+		// 用秒做浮点比较，避免高难度时换算成 time.Duration 溢出
+		expectedSeconds := math.Pow(16, float64(d)) / hashesPerSecond
+		if expectedSeconds >= minMineTime.Seconds() {
+			return d
 		}
 	}
-	return true // 所有检查通过，链有效
+	return hashHexLen
+}
+
+// BusiestBlock 返回交易笔数最多的区块（不计铸币交易），笔数相同时取高度最低者；
+// 链为空时返回 false。
+func (bc *Blockchain) BusiestBlock() (Block, bool) {
+	if len(bc.Blocks) == 0 {
+		return Block{}, false
+	}
+	best, bestCount := 0, -1
+	for i, b := range bc.Blocks {
+		count := 0
+		for _, tx := range b.Transactions {
+			if tx.From != "" {
+				count++
+			}
+		}
+		// 严格大于才替换，保证平局时保留更早的区块
+		if count > bestCount {
+			best, bestCount = i, count
+		}
+	}
+	return bc.Blocks[best], true
+}
+
+// VerifyPlausibleDifficulty 在导入不可信链时做防 DoS 检查：任一区块哈希实际达到的难度
+// （见 Block.AchievedDifficulty）超过 maxDifficulty 即拒绝，错误中给出第一个违规区块的高度。
+// 这样的哈希校验起来很便宜，但现实硬件几乎不可能挖出，通常意味着数据是伪造的。
+func (bc *Blockchain) VerifyPlausibleDifficulty(maxDifficulty int) error {
+	for _, b := range bc.Blocks {
+		if d := b.AchievedDifficulty(); d > maxDifficulty {
+			return fmt.Errorf("%w: block %d has difficulty %d > %d", ErrImplausibleDifficulty, b.Index, d, maxDifficulty)
+		}
+	}
+	return nil
+}
+
+// maxTraceTxs 是 TraceFlow 最多返回的交易笔数，防止在大链上输出失控。
+const maxTraceTxs = 1000
+
+// TraceFlow 从 startAddr 出发向前追踪资金流向：第 1 跳是 startAddr 转出的交易，
+// 第 2 跳是这些收款方在收到钱之后再转出的交易，依此类推，最多 maxHops 跳。
+// 每笔交易只返回一次（可处理循环转账），按发现顺序排列，最多 maxTraceTxs 笔。
+func (bc *Blockchain) TraceFlow(startAddr string, maxHops int) []Transaction {
+	// 把全链交易按上链顺序摊平，seq 越大越晚
+	var all []Transaction
+	for _, b := range bc.Blocks {
+		all = append(all, b.Transactions...)
+	}
+	// frontier 记录本跳要追踪的地址，以及它最早收到被追踪资金的位置
+	frontier := map[string]int{startAddr: -1}
+	included := make(map[int]bool)
+	var out []Transaction
+	for hop := 0; hop < maxHops && len(frontier) > 0; hop++ {
+		next := make(map[string]int)
+		for seq, tx := range all {
+			since, ok := frontier[tx.From]
+			// 只追踪收到资金之后发生的转出
+			if !ok || seq <= since || included[seq] {
+				continue
+			}
+			included[seq] = true
+			out = append(out, tx)
+			if len(out) >= maxTraceTxs {
+				return out
+			}
+			if prev, seen := next[tx.To]; !seen || seq < prev {
+				next[tx.To] = seq
+			}
+		}
+		frontier = next
+	}
+	return out
+}
+
+// VerifyCausalOrder 按区块顺序重放全部交易，检查每笔转出时付款方已经有足够的币，
+// 即不存在“先花后收”；返回第一笔违规交易所在的位置。铸币交易不受此限。
+// 约束粒度是区块：同一区块内收到的币在该高度即可花费，与交易在块内的先后无关。
+func (bc *Blockchain) VerifyCausalOrder() error {
+	balances := make(map[string]int)
+	for _, b := range bc.Blocks {
+		// 先计入本块的全部转入，再逐笔检查转出
+		for _, tx := range b.Transactions {
+			balances[tx.To] += tx.Amount
+		}
+		for i, tx := range b.Transactions {
+			if tx.From == "" {
+				continue
+			}
+			if balances[tx.From] < tx.Amount {
+				return fmt.Errorf("%w: block %d tx %d: %q spends %d but has %d",
+					ErrCausalOrder, b.Index, i, tx.From, tx.Amount, balances[tx.From])
+			}
+			balances[tx.From] -= tx.Amount
+		}
+	}
+	return nil
+}
+
+// MoneyVelocity 计算货币流通速度：最近 window 个区块内的转账总额（不含铸币）
+// 除以这段时间的平均货币供应量（截至各区块累计铸币量的平均值）。
+// window 超过链长时按整条链计算；window <= 0 或供应量为 0 时返回 0。
+func (bc *Blockchain) MoneyVelocity(window int) float64 {
+	if window <= 0 || len(bc.Blocks) == 0 {
+		return 0
+	}
+	start := max(len(bc.Blocks)-window, 0)
+	supply, volume, supplySum := 0, 0, 0
+	for i, b := range bc.Blocks {
+		for _, tx := range b.Transactions {
+			if tx.From == "" {
+				supply += tx.Amount
+			} else if i >= start {
+				volume += tx.Amount
+			}
+		}
+		// 窗口内每个区块都累计一次当时的供应量，用于求平均
+		if i >= start {
+			supplySum += supply
+		}
+	}
+	avgSupply := float64(supplySum) / float64(len(bc.Blocks)-start)
+	if avgSupply == 0 {
+		return 0
+	}
+	return float64(volume) / avgSupply
+}
+
+// HasDuplicateIndexes 检查是否有两个区块声明了相同的高度，返回第一个重复的高度。
+// 这是比完整校验更廉价的结构检查，适合在导入后先跑一遍。
+func (bc *Blockchain) HasDuplicateIndexes() (int, bool) {
+	seen := make(map[int]bool, len(bc.Blocks))
+	for _, b := range bc.Blocks {
+		if seen[b.Index] {
+			return b.Index, true
+		}
+		seen[b.Index] = true
+	}
+	return 0, false
+}
+
+// AssignMiner 按各矿工的算力占比 hashShares 随机决定谁挖出第 blockIndex 个区块，
+// 用于可复现的多矿工模拟。rng 为 nil 时以 blockIndex 为种子，同一高度结果固定。
+// 占比不必归一化；没有矿工或占比总和不为正时返回空串。
+func AssignMiner(blockIndex int, miners []string, hashShares []float64, rng *rand.Rand) string {
+	n := min(len(miners), len(hashShares))
+	total := 0.0
+	for _, share := range hashShares[:n] {
+		total += max(share, 0)
+	}
+	if total <= 0 {
+		return ""
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewSource(int64(blockIndex)))
+	}
+	// 在 [0, total) 上取一点，落在哪个矿工的累计区间就归谁
+	r := rng.Float64() * total
+	last := ""
+	for i, share := range hashShares[:n] {
+		if share <= 0 {
+			continue
+		}
+		last = miners[i]
+		if r -= share; r < 0 {
+			return last
+		}
+	}
+	return last // 浮点误差兜底：归最后一个有算力的矿工
 }
 
 // NewBlockchain 创建一条带有创世区块的新链，并设置全局难度。
-func NewBlockchain(difficulty int) *Blockchain {
-	// 先生成创世区块
-	genesis := newGenesisBlock(difficulty)
+// 难度不可能达成时返回 ErrImpossibleDifficulty。
+func NewBlockchain(difficulty int) (*Blockchain, error) {
+	return newBlockchain(difficulty, []Transaction{}, "")
+}
+
+// NewSaltedBlockchain 与 NewBlockchain 相同，但链的 PoWSalt 在创建时即确定，
+// 创世区块也按该盐挖出，整条链的哈希都与其他用途的链隔离。
+func NewSaltedBlockchain(difficulty int, salt string) (*Blockchain, error) {
+	return newBlockchain(difficulty, []Transaction{}, salt)
+}
+
+// GenesisFromBalances 读取 ExportBalances 写出的余额表，创建一条新链，
+// 其创世区块按地址顺序为每个地址铸造对应数量的币（零余额也保留一笔 0 金额的分配），
+// 因此对余额全部非负的链，导出再导入得到的余额表与原表完全相同。负余额无法铸造，返回错误。
+func GenesisFromBalances(r io.Reader, difficulty int) (*Blockchain, error) {
+	var balances map[string]int
+	if err := json.NewDecoder(r).Decode(&balances); err != nil {
+		return nil, fmt.Errorf("decode balances: %w", err)
+	}
+	// 按地址排序，保证同一份余额表生成的创世交易顺序固定
+	addrs := make([]string, 0, len(balances))
+	for addr := range balances {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	allocs := []Transaction{}
+	for _, addr := range addrs {
+		amount := balances[addr]
+		if amount < 0 {
+			return nil, fmt.Errorf("cannot allocate negative balance %d to %q", amount, addr)
+		}
+		allocs = append(allocs, Transaction{From: "", To: addr, Amount: amount})
+	}
+	return newBlockchain(difficulty, allocs, "")
+}
+
+// newBlockchain 用给定的创世分配与盐创建新链，NewBlockchain、NewSaltedBlockchain 与 GenesisFromBalances 共用。
+func newBlockchain(difficulty int, allocs []Transaction, salt string) (*Blockchain, error) {
+	// 先校验难度，再生成创世区块
+	if err := checkDifficulty(difficulty); err != nil {
+		return nil, err
+	}
+	genesis, err := newGenesisBlock(difficulty, allocs, salt)
+	if err != nil {
+		return nil, err
+	}
 	// 初始化链结构体并返回指针
 	return &Blockchain{
 		Blocks:     []Block{genesis},
 		Difficulty: difficulty,
+		PoWSalt:    salt,
+	}, nil
+}
+
+// displayHash 把哈希截断为前 n 个字符并加省略号，仅用于展示；
+// n <= 0 或不短于哈希长度时原样返回。
+func displayHash(h string, n int) string {
+	if n <= 0 || n >= len(h) {
+		return h
 	}
+	return h[:n] + "..."
 }
 
-// printBlock 辅助函数：友好地打印一个区块的关键字段。
-func printBlock(b Block) {
+// printBlock 辅助函数：友好地打印一个区块的关键字段（哈希按 HashDisplayLen 截断）。
+func (bc *Blockchain) printBlock(b Block) {
 	fmt.Println("---------------- block ----------------")
 	fmt.Println("Index:", b.Index)
 	fmt.Println("Timestamp:", b.Timestamp)
-	fmt.Println("PrevHash:", b.PrevHash)
-	fmt.Println("Hash:", b.Hash)
+	fmt.Println("PrevHash:", displayHash(b.PrevHash, bc.HashDisplayLen))
+	fmt.Println("Hash:", displayHash(b.Hash, bc.HashDisplayLen))
 	fmt.Println("Nonce:", b.Nonce)
 	fmt.Println("Txs:")
 	for i, tx := range b.Transactions {
@@ -181,25 +1490,37 @@ func main() {
 	// 设定一个适中的难度（本地演示建议 4~5；数字越大越慢）
 	difficulty := 4
 	// 创建一条新区块链（自动带创世区块）
-	bc := NewBlockchain(difficulty)
+	bc, err := NewBlockchain(difficulty)
+	if err != nil {
+		fmt.Println("create chain failed:", err)
+		return
+	}
 	// 打印创世区块
-	printBlock(bc.Blocks[0])
+	bc.printBlock(bc.Blocks[0])
 
 	// 组装第一批交易并打包成区块
 	txs1 := []Transaction{
 		{From: "alice", To: "bob", Amount: 10},
 		{From: "carol", To: "dave", Amount: 5},
 	}
-	b1 := bc.AddBlock(txs1)
-	printBlock(b1)
+	b1, err := bc.AddBlock(txs1)
+	if err != nil {
+		fmt.Println("add block failed:", err)
+		return
+	}
+	bc.printBlock(b1)
 
 	// 再组装第二批交易，继续出块
 	txs2 := []Transaction{
 		{From: "bob", To: "alice", Amount: 3},
 		{From: "dave", To: "carol", Amount: 2},
 	}
-	b2 := bc.AddBlock(txs2)
-	printBlock(b2)
+	b2, err := bc.AddBlock(txs2)
+	if err != nil {
+		fmt.Println("add block failed:", err)
+		return
+	}
+	bc.printBlock(b2)
 
 	// 最后校验一下整条链是否有效
 	fmt.Println("chain valid:", bc.IsValid())
@@ -0,0 +1,123 @@
+package main
+
+// 交易列表的字典压缩编码：存储层格式，区块内第二次出现的地址只写一个小整数编号。
+// 共识哈希仍按 serializeTransactions 的展开形式计算，与本编码无关。
+// 导入标准库：encoding/binary 读写变长整数；errors/fmt 构造错误。
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrCorruptEncoding 表示压缩编码的数据被截断或引用了不存在的地址编号。
+var ErrCorruptEncoding = errors.New("corrupt compact encoding")
+
+// EncodeTransactionsCompact 把交易列表编码为紧凑字节流。每个地址写成一个 uvarint：
+// 0 表示后面紧跟新地址（uvarint 长度 + 字节），并为它分配下一个编号；
+// n > 0 表示引用编号为 n-1 的已出现地址。金额写成 varint，脚本写成 uvarint 长度 + 字节。
+func EncodeTransactionsCompact(txs []Transaction) []byte {
+	var buf []byte
+	ids := make(map[string]uint64)
+	putAddr := func(addr string) {
+		if id, ok := ids[addr]; ok {
+			buf = binary.AppendUvarint(buf, id+1)
+			return
+		}
+		ids[addr] = uint64(len(ids))
+		buf = binary.AppendUvarint(buf, 0)
+		buf = binary.AppendUvarint(buf, uint64(len(addr)))
+		buf = append(buf, addr...)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(txs)))
+	for _, tx := range txs {
+		putAddr(tx.From)
+		putAddr(tx.To)
+		buf = binary.AppendVarint(buf, int64(tx.Amount))
+		buf = binary.AppendUvarint(buf, uint64(len(tx.Script)))
+		buf = append(buf, tx.Script...)
+	}
+	return buf
+}
+
+// DecodeTransactionsCompact 还原 EncodeTransactionsCompact 的输出；数据不完整时返回 ErrCorruptEncoding。
+// 空脚本还原为 nil，与 serializeTransactions 的处理一致，因此还原后的区块哈希不变。
+func DecodeTransactionsCompact(data []byte) ([]Transaction, error) {
+	pos := 0
+	// uvarint 读取一个无符号变长整数
+	uvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return 0, fmt.Errorf("%w: bad uvarint at %d", ErrCorruptEncoding, pos)
+		}
+		pos += n
+		return v, nil
+	}
+	// bytesN 读取长度前缀之后的 n 个字节
+	bytesN := func() ([]byte, error) {
+		n, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(data)-pos) {
+			return nil, fmt.Errorf("%w: %d bytes at %d exceed input", ErrCorruptEncoding, n, pos)
+		}
+		b := data[pos : pos+int(n)]
+		pos += int(n)
+		return b, nil
+	}
+	var dict []string
+	addr := func() (string, error) {
+		ref, err := uvarint()
+		if err != nil {
+			return "", err
+		}
+		if ref == 0 {
+			b, err := bytesN()
+			if err != nil {
+				return "", err
+			}
+			dict = append(dict, string(b))
+			return string(b), nil
+		}
+		if ref > uint64(len(dict)) {
+			return "", fmt.Errorf("%w: unknown address id %d", ErrCorruptEncoding, ref-1)
+		}
+		return dict[ref-1], nil
+	}
+	count, err := uvarint()
+	if err != nil {
+		return nil, err
+	}
+	// 每笔交易至少占 4 个字节，据此拒绝伪造的超大计数，避免一次性分配过多内存
+	if count > uint64(len(data))/4 {
+		return nil, fmt.Errorf("%w: %d transactions in %d bytes", ErrCorruptEncoding, count, len(data))
+	}
+	txs := make([]Transaction, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var tx Transaction
+		if tx.From, err = addr(); err != nil {
+			return nil, err
+		}
+		if tx.To, err = addr(); err != nil {
+			return nil, err
+		}
+		amount, n := binary.Varint(data[pos:])
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: bad amount at %d", ErrCorruptEncoding, pos)
+		}
+		pos += n
+		tx.Amount = int(amount)
+		script, err := bytesN()
+		if err != nil {
+			return nil, err
+		}
+		if len(script) > 0 {
+			tx.Script = append([]byte(nil), script...)
+		}
+		txs = append(txs, tx)
+	}
+	if pos != len(data) {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrCorruptEncoding, len(data)-pos)
+	}
+	return txs, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+// 终局性（finality）：验证者对区块哈希签名“背书”，获得超过三分之二验证者背书的区块即被最终确认。
+// 验证者集合就是 StakerKeys 中登记的地址，按人数计票。
+// 导入标准库：crypto/ed25519 校验背书签名；errors/fmt 构造错误。
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidAttestation 表示背书的高度不存在、验证者未登记或签名无效。
+var ErrInvalidAttestation = errors.New("invalid attestation")
+
+// attestationDomain 是背书消息的前缀，使背书签名与出块签名（直接签区块哈希）互相隔离，
+// 区块自带的 ValidatorSig 不能被冒充为背书。
+const attestationDomain = "attest|"
+
+// AttestationMessage 返回验证者为背书区块应签名的消息："attest|" + 区块哈希。
+func AttestationMessage(blockHash string) []byte {
+	return []byte(attestationDomain + blockHash)
+}
+
+// Attest 记录验证者 validator 对高度 height 区块的背书，sig 须是其登记公钥对
+// AttestationMessage(区块哈希) 的签名。
+// 同一验证者对同一区块重复背书只计一次。
+func (bc *Blockchain) Attest(height int, validator string, sig []byte) error {
+	if height < 0 || height >= len(bc.Blocks) {
+		return fmt.Errorf("%w: no block at height %d", ErrInvalidAttestation, height)
+	}
+	pub, ok := bc.StakerKeys[validator]
+	if !ok || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: unknown validator %q", ErrInvalidAttestation, validator)
+	}
+	if !ed25519.Verify(pub, AttestationMessage(bc.Blocks[height].Hash), sig) {
+		return fmt.Errorf("%w: bad signature from %q", ErrInvalidAttestation, validator)
+	}
+	if bc.Attestations == nil {
+		bc.Attestations = make(map[int]map[string]bool)
+	}
+	if bc.Attestations[height] == nil {
+		bc.Attestations[height] = make(map[string]bool)
+	}
+	bc.Attestations[height][validator] = true
+	// 首次达到终局时，记下当时的链尾高度
+	if _, done := bc.FinalizedAt[height]; !done && bc.IsFinalized(height) {
+		if bc.FinalizedAt == nil {
+			bc.FinalizedAt = make(map[int]int)
+		}
+		bc.FinalizedAt[height] = len(bc.Blocks) - 1
+	}
+	return nil
+}
+
+// IsFinalized 判断高度 height 的区块是否获得了超过三分之二验证者的背书。
+func (bc *Blockchain) IsFinalized(height int) bool {
+	validators := len(bc.StakerKeys)
+	if validators == 0 {
+		return false
+	}
+	return 3*len(bc.Attestations[height]) > 2*validators
+}
+
+// BlockFinality 是单个区块的终局状态，供共识看板展示。
+type BlockFinality struct {
+	Height       int  // 区块高度
+	Attestations int  // 已获得的背书数
+	Finalized    bool // 是否已最终确认
+	FinalizedAt  int  // 首次达到终局时的链尾高度，未确认时为 -1
+}
+
+// FinalityStatus 按高度返回每个区块的背书数与终局状态。
+func (bc *Blockchain) FinalityStatus() []BlockFinality {
+	status := make([]BlockFinality, len(bc.Blocks))
+	for i := range bc.Blocks {
+		f := BlockFinality{
+			Height:       i,
+			Attestations: len(bc.Attestations[i]),
+			Finalized:    bc.IsFinalized(i),
+			FinalizedAt:  -1,
+		}
+		if at, ok := bc.FinalizedAt[i]; ok {
+			f.FinalizedAt = at
+		}
+		status[i] = f
+	}
+	return status
+}
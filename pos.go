@@ -0,0 +1,214 @@
+package main
+
+// 权益证明（PoS）：下一个区块由谁出，按各地址余额加权抽签决定，
+// 抽签种子取自上一区块的哈希，因此任何节点都能复算并校验。
+// 导入标准库：bytes 比较公钥；crypto/ed25519 校验签名；crypto/sha256 与
+// encoding/binary 从哈希派生种子；errors/fmt 构造错误；sort 保证抽签顺序稳定。
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNoStakers 表示在该高度没有任何余额为正的地址可以被抽中。
+var ErrNoStakers = errors.New("no address with positive stake")
+
+// ErrWrongStaker 表示区块不是由该高度抽中的质押者产生或签名的。
+var ErrWrongStaker = errors.New("block not produced by the selected staker")
+
+// ErrInvalidSlashProof 表示罚没证明不能证明同一质押者在同一高度签了两个不同区块。
+var ErrInvalidSlashProof = errors.New("invalid slash proof")
+
+// ErrSlashedAddress 表示交易试图从已被罚没的地址转出。
+var ErrSlashedAddress = errors.New("address has been slashed")
+
+// SelectStaker 返回高度 height 的合法出块者：以该高度之前的余额为权重，
+// 用上一区块哈希派生的种子做确定性抽签。罚没生效高度及之后，被罚没者不再参与抽签；
+// 没有可抽的正余额地址时返回 false。
+func (bc *Blockchain) SelectStaker(height int) (string, bool) {
+	if height <= 0 || height > len(bc.Blocks) {
+		return "", false
+	}
+	// 只统计该高度之前已上链的余额，按地址排序保证各节点抽签顺序一致
+	balances := replayBalances(bc.Blocks[:height], nil)
+	addrs := make([]string, 0, len(balances))
+	total := uint64(0)
+	for addr, v := range balances {
+		if v > 0 && !bc.slashedAt(addr, height) {
+			addrs = append(addrs, addr)
+			total += uint64(v)
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+	sort.Strings(addrs)
+	// 种子取上一区块哈希的 SHA-256 前 8 字节
+	seed := sha256.Sum256([]byte(bc.Blocks[height-1].Hash))
+	r := binary.BigEndian.Uint64(seed[:8]) % total
+	for _, addr := range addrs {
+		stake := uint64(balances[addr])
+		if r < stake {
+			return addr, true
+		}
+		r -= stake
+	}
+	return addrs[len(addrs)-1], true // 不会走到这里，兜底
+}
+
+// stakeBlock 在 PoS 模式下出块：本节点必须是该高度抽中的质押者，
+// 且 ValidatorKey 与 StakerKeys 中登记的公钥一致。
+func (bc *Blockchain) stakeBlock(b *Block) error {
+	if len(bc.ValidatorKey) != ed25519.PrivateKeySize {
+		return ErrNoValidatorKey
+	}
+	selected, ok := bc.SelectStaker(b.Index)
+	if !ok {
+		return ErrNoStakers
+	}
+	if selected != bc.StakerAddress {
+		return fmt.Errorf("%w: height %d belongs to %q", ErrWrongStaker, b.Index, selected)
+	}
+	if !bytes.Equal(bc.StakerKeys[selected], bc.ValidatorKey.Public().(ed25519.PublicKey)) {
+		return fmt.Errorf("%w: validator key not registered for %q", ErrWrongStaker, selected)
+	}
+	b.Producer = selected
+	bc.sealBlock(b)
+	return nil
+}
+
+// verifyStaker 校验 PoS 区块：出块者是该高度抽中的质押者，并且签名来自其登记的公钥。
+func (bc *Blockchain) verifyStaker(b Block) error {
+	selected, ok := bc.SelectStaker(b.Index)
+	if !ok || b.Producer != selected {
+		return fmt.Errorf("%w: block %d producer %q, selected %q", ErrWrongStaker, b.Index, b.Producer, selected)
+	}
+	pub := bc.StakerKeys[b.Producer]
+	if len(pub) != ed25519.PublicKeySize || !bytes.Equal(b.ValidatorPubKey, pub) {
+		return fmt.Errorf("%w: block %d signer is not %q", ErrWrongStaker, b.Index, b.Producer)
+	}
+	if !ed25519.Verify(pub, []byte(b.Hash), b.ValidatorSig) {
+		return fmt.Errorf("%w: block %d bad signature", ErrWrongStaker, b.Index)
+	}
+	return nil
+}
+
+// Equivocation 记录一次“同一质押者在同一高度签了两个不同区块”的可罚没行为。
+type Equivocation struct {
+	Staker string // 作恶的质押者地址
+	Height int    // 发生冲突的高度
+	BlockA Block  // 冲突区块之一
+	BlockB Block  // 与 BlockA 哈希不同的另一个区块
+}
+
+// DetectEquivocation 在本链区块与外部传入的 blocks 中查找双重出块：
+// 同一质押者在同一高度签名了哈希不同的两个区块。只统计签名确实有效的区块，
+// 避免他人伪造区块来栽赃；每个（质押者，高度）只报告一次。
+func (bc *Blockchain) DetectEquivocation(blocks []Block) []Equivocation {
+	type slot struct {
+		staker string
+		height int
+	}
+	first := make(map[slot]Block)
+	reported := make(map[slot]bool)
+	var found []Equivocation
+	for _, b := range append(append([]Block{}, bc.Blocks...), blocks...) {
+		if !bc.signedByStaker(b) {
+			continue
+		}
+		key := slot{b.Producer, b.Index}
+		prev, seen := first[key]
+		if !seen {
+			first[key] = b
+			continue
+		}
+		if prev.Hash != b.Hash && !reported[key] {
+			reported[key] = true
+			found = append(found, Equivocation{Staker: b.Producer, Height: b.Index, BlockA: prev, BlockB: b})
+		}
+	}
+	return found
+}
+
+// signedByStaker 判断区块内容与哈希一致，且由 Producer 登记的公钥签名。
+func (bc *Blockchain) signedByStaker(b Block) bool {
+	pub := bc.StakerKeys[b.Producer]
+	if b.Producer == "" || len(pub) != ed25519.PublicKeySize || !bytes.Equal(b.ValidatorPubKey, pub) {
+		return false
+	}
+	if calculateHash(b, bc.PoWSalt) != b.Hash {
+		return false
+	}
+	return ed25519.Verify(pub, []byte(b.Hash), b.ValidatorSig)
+}
+
+// SlashProof 是罚没证明：同一质押者在同一高度签名的两个不同区块。
+type SlashProof struct {
+	BlockA Block
+	BlockB Block
+}
+
+// SubmitSlashProof 校验罚没证明，有效则把作恶者记入 Slashed：其余额视为 0、不能再转出，
+// 并从下一个高度起不再参与出块抽签。罚没记录保存在本节点，不改变链上区块，
+// 已有区块的抽签与校验不受影响。
+func (bc *Blockchain) SubmitSlashProof(p SlashProof) error {
+	a, b := p.BlockA, p.BlockB
+	if a.Producer != b.Producer || a.Index != b.Index {
+		return fmt.Errorf("%w: blocks are from different stakers or heights", ErrInvalidSlashProof)
+	}
+	if a.Hash == b.Hash {
+		return fmt.Errorf("%w: blocks are identical", ErrInvalidSlashProof)
+	}
+	if !bc.signedByStaker(a) || !bc.signedByStaker(b) {
+		return fmt.Errorf("%w: signature check failed", ErrInvalidSlashProof)
+	}
+	if _, done := bc.Slashed[a.Producer]; done {
+		return nil // 已罚没过，保留最早的生效高度
+	}
+	if bc.Slashed == nil {
+		bc.Slashed = make(map[string]int)
+	}
+	bc.Slashed[a.Producer] = len(bc.Blocks)
+	return nil
+}
+
+// slashedAt 判断地址在高度 height 的抽签中是否已被罚没。
+func (bc *Blockchain) slashedAt(addr string, height int) bool {
+	at, ok := bc.Slashed[addr]
+	return ok && height >= at
+}
+
+// StakeDistribution 返回每个正余额地址占全部质押的比例，即它抽中下一个区块的概率；
+// 与 SelectStaker 一致，只看链上余额并排除已被罚没的地址。没有可抽地址时返回空表。
+func (bc *Blockchain) StakeDistribution() map[string]float64 {
+	next := len(bc.Blocks)
+	stakes := make(map[string]int)
+	total := 0
+	for addr, v := range replayBalances(bc.Blocks, nil) {
+		if v > 0 && !bc.slashedAt(addr, next) {
+			stakes[addr] = v
+			total += v
+		}
+	}
+	dist := make(map[string]float64, len(stakes))
+	for addr, v := range stakes {
+		dist[addr] = float64(v) / float64(total)
+	}
+	return dist
+}
+
+// EffectiveStakers 按地址排序返回链上余额不少于 minStake（且为正）、未被罚没的质押者。
+func (bc *Blockchain) EffectiveStakers(minStake int) []string {
+	var stakers []string
+	for addr, v := range replayBalances(bc.Blocks, nil) {
+		if v > 0 && v >= minStake && !bc.slashedAt(addr, len(bc.Blocks)) {
+			stakers = append(stakers, addr)
+		}
+	}
+	sort.Strings(stakers)
+	return stakers
+}
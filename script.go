@@ -0,0 +1,72 @@
+package main
+
+// 极简栈式脚本：交易可携带一段 Script，校验时由解释器执行，执行成功交易才有效。
+// 导入标准库：errors 定义错误值；fmt 给错误附加位置信息。
+import (
+	"errors"
+	"fmt"
+)
+
+// 脚本操作码。栈元素为 int64。
+const (
+	OpPush   byte = 0x01 // 把紧随其后的 1 个字节作为数值（0~255）压栈
+	OpAdd    byte = 0x02 // 弹出两个数，压入它们的和
+	OpEqual  byte = 0x03 // 弹出两个数，相等压入 1，否则压入 0
+	OpVerify byte = 0x04 // 弹出一个数，为 0 则脚本失败
+)
+
+// ErrScriptFailed 表示交易脚本执行失败（栈下溢、未知操作码或校验不通过）。
+var ErrScriptFailed = errors.New("script failed")
+
+// runScript 执行脚本：全部指令执行完且栈为空或栈顶非 0 时视为成功。
+func runScript(script []byte) error {
+	var stack []int64
+	// pop 弹出栈顶，栈空时返回 false
+	pop := func() (int64, bool) {
+		if len(stack) == 0 {
+			return 0, false
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, true
+	}
+	for pc := 0; pc < len(script); pc++ {
+		switch op := script[pc]; op {
+		case OpPush:
+			// 操作数是下一个字节
+			if pc+1 >= len(script) {
+				return fmt.Errorf("%w: push at %d missing operand", ErrScriptFailed, pc)
+			}
+			pc++
+			stack = append(stack, int64(script[pc]))
+		case OpAdd, OpEqual:
+			a, okA := pop()
+			b, okB := pop()
+			if !okA || !okB {
+				return fmt.Errorf("%w: stack underflow at %d", ErrScriptFailed, pc)
+			}
+			if op == OpAdd {
+				stack = append(stack, a+b)
+			} else if a == b {
+				stack = append(stack, 1)
+			} else {
+				stack = append(stack, 0)
+			}
+		case OpVerify:
+			v, ok := pop()
+			if !ok {
+				return fmt.Errorf("%w: stack underflow at %d", ErrScriptFailed, pc)
+			}
+			if v == 0 {
+				return fmt.Errorf("%w: verify at %d", ErrScriptFailed, pc)
+			}
+		default:
+			return fmt.Errorf("%w: unknown opcode 0x%02x at %d", ErrScriptFailed, op, pc)
+		}
+	}
+	// 结束时栈顶为 0 同样视为失败
+	if len(stack) > 0 && stack[len(stack)-1] == 0 {
+		return fmt.Errorf("%w: false on top of stack", ErrScriptFailed)
+	}
+	return nil
+}